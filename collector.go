@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DockerServices implements the Collector interface.
+//
+// Rather than hitting the Docker API on every scrape, it keeps an
+// in-memory cache of services, tasks and containers which is kept up to
+// date by a background goroutine watching the Docker event stream. The
+// cache is reconciled against a full list on startup and on every
+// resyncInterval, so a missed or misordered event can't permanently
+// desync the cache.
+type DockerServices struct {
+	*client.Client
+
+	resyncInterval time.Duration
+
+	mu         sync.RWMutex
+	services   []swarm.Service
+	tasks      []swarm.Task
+	containers []types.ContainerJSON
+	nodes      []swarm.Node
+	cluster    *swarm.ClusterInfo
+
+	// pendingReconcile is set by watchEvents when an event arrives and
+	// drained by a short debounce timer, so a burst of events (e.g. a
+	// container's healthcheck firing every few seconds) coalesces into a
+	// single reconcile instead of one per message.
+	pendingReconcile chan struct{}
+
+	// stats is non-nil when ENABLE_STATS=1, enabling per-container resource metrics.
+	stats *statsCollector
+}
+
+var _ prometheus.Collector = (*DockerServices)(nil)
+
+var (
+	swarmExporterUp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "swarm_exporter_up",
+		Help: "Whether the last reconcile against the Docker API succeeded",
+	})
+	swarmExporterScrapeErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "swarm_exporter_scrape_errors_total",
+		Help: "Number of errors encountered while reconciling state from the Docker API, by operation",
+	}, []string{"op"})
+)
+
+// NewDockerServices builds a DockerServices collector backed by dockerClient.
+// Call Run to start the background cache-refresh goroutine before
+// registering the collector with Prometheus.
+func NewDockerServices(dockerClient *client.Client, resyncInterval time.Duration) *DockerServices {
+	prometheus.MustRegister(swarmExporterUp, swarmExporterScrapeErrors)
+
+	return &DockerServices{
+		Client:           dockerClient,
+		resyncInterval:   resyncInterval,
+		pendingReconcile: make(chan struct{}, 1),
+	}
+}
+
+// Run reconciles the cache once synchronously, so the first scrape has
+// data to serve, then starts the background event-watch and debounce loops.
+// It returns once the initial reconcile has completed.
+func (c *DockerServices) Run(ctx context.Context) error {
+	if err := c.reconcile(ctx); err != nil {
+		return err
+	}
+	go c.watchEvents(ctx)
+	go c.debounceReconcile(ctx)
+	return nil
+}
+
+// debounceReconcileWindow is how long debounceReconcile waits after the first
+// event in a burst before actually reconciling, so a flurry of routine
+// container events (health checks, exec_* noise) collapses into a single
+// reconcile instead of one per message.
+const debounceReconcileWindow = 2 * time.Second
+
+// debounceReconcile drains pendingReconcile signals and performs at most
+// one reconcile per debounceReconcileWindow, however many events arrived
+// in that window.
+func (c *DockerServices) debounceReconcile(ctx context.Context) {
+	timer := time.NewTimer(debounceReconcileWindow)
+	if !timer.Stop() {
+		<-timer.C
+	}
+	armed := false
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.pendingReconcile:
+			if !armed {
+				timer.Reset(debounceReconcileWindow)
+				armed = true
+			}
+		case <-timer.C:
+			armed = false
+			if err := c.reconcile(ctx); err != nil {
+				logger.Error().Err(err).Msgf("Error reconciling cache after debounced event.")
+			}
+		}
+	}
+}
+
+// reconcile replaces the cache with a fresh full listing from the Docker API.
+// Each sub-call's failure is counted under its own "op" label on
+// swarmExporterScrapeErrors, and swarmExporterUp reflects whether the last
+// attempt succeeded, so a transient daemon hiccup shows up in metrics
+// instead of crashing the exporter.
+func (c *DockerServices) reconcile(ctx context.Context) error {
+	services, err := c.Client.ServiceList(ctx, types.ServiceListOptions{})
+	if err != nil {
+		swarmExporterScrapeErrors.WithLabelValues("service_list").Inc()
+		swarmExporterUp.Set(0)
+		return err
+	}
+
+	tasks, err := c.Client.TaskList(ctx, types.TaskListOptions{})
+	if err != nil {
+		swarmExporterScrapeErrors.WithLabelValues("task_list").Inc()
+		swarmExporterUp.Set(0)
+		return err
+	}
+
+	containers, err := c.Client.ContainerList(ctx, types.ContainerListOptions{})
+	if err != nil {
+		swarmExporterScrapeErrors.WithLabelValues("container_list").Inc()
+		swarmExporterUp.Set(0)
+		return err
+	}
+
+	containerDetails := make([]types.ContainerJSON, 0, len(containers))
+	for _, container := range containers {
+		detail, err := c.Client.ContainerInspect(ctx, container.ID)
+		if err != nil {
+			// A container can disappear between ContainerList and this
+			// inspect call (e.g. a scale-down racing with this reconcile) -
+			// that's routine churn, not a daemon-wide problem, so skip it
+			// and keep building the cache from what's left rather than
+			// discarding the whole listing.
+			swarmExporterScrapeErrors.WithLabelValues("container_inspect").Inc()
+			logger.Debug().Err(err).Str("container", container.ID).Msgf("Error inspecting container, skipping it for this reconcile.")
+			continue
+		}
+		containerDetails = append(containerDetails, detail)
+	}
+
+	c.mu.Lock()
+	c.services = services
+	c.tasks = tasks
+	c.containers = containerDetails
+	c.mu.Unlock()
+
+	swarmExporterUp.Set(1)
+
+	if c.stats != nil {
+		c.stats.reconcileWatchers(ctx, containerDetails)
+	}
+
+	c.reconcileNodes(ctx)
+
+	return nil
+}
+
+// watchEvents subscribes to the Docker event stream and schedules a
+// (debounced) reconcile whenever a service, task or container changes.
+// It reconnects with backoff on socket errors and forces a full reconcile
+// every resyncInterval regardless of event traffic.
+func (c *DockerServices) watchEvents(ctx context.Context) {
+	backoff := time.Second
+	const maxBackoff = 30 * time.Second
+
+	resync := time.NewTicker(c.resyncInterval)
+	defer resync.Stop()
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		// Docker has no dedicated "task" event type; task state changes are
+		// observed via container events plus the periodic resync below.
+		eventFilters := filters.NewArgs(
+			filters.Arg("type", string(events.ServiceEventType)),
+			filters.Arg("type", string(events.ContainerEventType)),
+		)
+		msgs, errs := c.Client.Events(ctx, types.EventsOptions{Filters: eventFilters})
+
+		logger.Debug().Msgf("Subscribed to Docker event stream.")
+
+	eventLoop:
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-resync.C:
+				if err := c.reconcile(ctx); err != nil {
+					logger.Error().Err(err).Msgf("Error during scheduled resync.")
+				}
+			case err := <-errs:
+				if err != nil {
+					logger.Warn().Err(err).Msgf("Docker event stream error, reconnecting.")
+				}
+				break eventLoop
+			case msg := <-msgs:
+				// A delivered event proves the stream is healthy again.
+				backoff = time.Second
+				if isNoisyEventAction(msg.Action) {
+					continue
+				}
+				select {
+				case c.pendingReconcile <- struct{}{}:
+				default:
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// isNoisyEventAction reports whether action is emitted continuously by
+// routine container activity (exec sessions used for healthchecks and
+// similar) and carries no swarm-state change worth reconciling for.
+func isNoisyEventAction(action string) bool {
+	return strings.HasPrefix(action, "exec_")
+}
+
+func (c *DockerServices) Describe(ch chan<- *prometheus.Desc) {
+	ch <- replicaCount
+	ch <- taskCount
+	ch <- imageVersion
+	ch <- lastChangeTime
+	ch <- nodeInfo
+	ch <- nodeAvailability
+	ch <- nodeStatus
+	ch <- nodeManagerReachability
+	ch <- nodeIsLeader
+	ch <- swarmInfo
+	ch <- swarmManagersTotal
+	ch <- swarmNodesTotal
+}
+
+// Collect emits metrics from the in-memory cache under an RLock, so a
+// scrape never blocks on the Docker API.
+func (c *DockerServices) Collect(ch chan<- prometheus.Metric) {
+	logger.Debug().Msgf("Received request for metrics.")
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, container := range c.containers {
+		ch <- prometheus.MustNewConstMetric(
+			containerStatus,
+			prometheus.GaugeValue,
+			float64(1),
+			container.State.Status,
+			container.Name,
+		)
+
+		if container.State.Health != nil {
+			ch <- prometheus.MustNewConstMetric(
+				containerHealthStatus,
+				prometheus.GaugeValue,
+				float64(1),
+				container.State.Health.Status,
+				container.Name,
+			)
+		}
+	}
+
+	for _, service := range c.services {
+		if service.Spec.Mode.Replicated != nil {
+			ch <- prometheus.MustNewConstMetric(
+				replicaCount,
+				prometheus.GaugeValue,
+				float64(*service.Spec.Mode.Replicated.Replicas),
+				service.Spec.Annotations.Name,
+			)
+		}
+
+		taskStates := make(map[string]int)
+		var lastTaskStatusChange time.Time
+		for _, task := range c.tasks {
+			if task.ServiceID == service.ID {
+				taskStates[string(task.Status.State)] += 1
+				if task.Status.Timestamp.After(lastTaskStatusChange) {
+					lastTaskStatusChange = task.Status.Timestamp
+				}
+			}
+		}
+
+		for state, count := range taskStates {
+			ch <- prometheus.MustNewConstMetric(
+				taskCount,
+				prometheus.GaugeValue,
+				float64(count),
+				service.Spec.Annotations.Name,
+				string(state),
+			)
+		}
+
+		// See https://www.robustperception.io/exposing-the-software-version-to-prometheus
+		ch <- prometheus.MustNewConstMetric(
+			imageVersion,
+			prometheus.GaugeValue,
+			1,
+			service.Spec.Annotations.Name,
+			string(service.Spec.TaskTemplate.ContainerSpec.Image),
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			lastChangeTime,
+			prometheus.GaugeValue,
+			float64(lastTaskStatusChange.Unix()),
+			service.Spec.Annotations.Name,
+		)
+	}
+
+	c.collectNodes(ch)
+
+	if c.stats != nil {
+		c.stats.collect(ch)
+	}
+}
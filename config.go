@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// Config holds the exporter's runtime configuration. Each field can be set
+// via a flag or its matching envvar fallback; the flag wins if both are given.
+type Config struct {
+	ListenAddress   string
+	MetricsPath     string
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSClientCAFile string
+	BasicAuthUser   string
+	BasicAuthPass   string
+	EnablePprof     bool
+	EnableGoMetrics bool
+}
+
+func envOrDefault(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func envBool(key string) bool {
+	return os.Getenv(key) == "1"
+}
+
+// bindConfigFlags registers the exporter's flags on cmd, defaulting each
+// to its matching envvar.
+func bindConfigFlags(cmd *cobra.Command, cfg *Config) {
+	flags := cmd.Flags()
+	flags.StringVar(&cfg.ListenAddress, "listen-address", envOrDefault("LISTEN_ADDRESS", ":9675"), "Address to listen on for HTTP requests")
+	flags.StringVar(&cfg.MetricsPath, "metrics-path", envOrDefault("METRICS_PATH", "/metrics"), "Path under which to expose metrics")
+	flags.StringVar(&cfg.TLSCertFile, "tls-cert-file", envOrDefault("TLS_CERT_FILE", ""), "TLS certificate file; enables HTTPS when set together with --tls-key-file")
+	flags.StringVar(&cfg.TLSKeyFile, "tls-key-file", envOrDefault("TLS_KEY_FILE", ""), "TLS private key file; enables HTTPS when set together with --tls-cert-file")
+	flags.StringVar(&cfg.TLSClientCAFile, "tls-client-ca-file", envOrDefault("TLS_CLIENT_CA_FILE", ""), "CA file for verifying client certificates, enables mTLS")
+	flags.StringVar(&cfg.BasicAuthUser, "basic-auth-user", envOrDefault("BASIC_AUTH_USER", ""), "Username for HTTP basic auth; auth is enabled when a user or password is set")
+	flags.StringVar(&cfg.BasicAuthPass, "basic-auth-pass", envOrDefault("BASIC_AUTH_PASS", ""), "Password for HTTP basic auth; auth is enabled when a user or password is set")
+	flags.BoolVar(&cfg.EnablePprof, "pprof", envBool("ENABLE_PPROF"), "Register net/http/pprof handlers under /debug/pprof")
+	flags.BoolVar(&cfg.EnableGoMetrics, "go-metrics", envBool("ENABLE_GO_METRICS"), "Re-enable the Go runtime and process metrics collectors")
+}
+
+// tlsEnabled reports whether cfg carries enough to serve HTTPS.
+func (cfg *Config) tlsEnabled() bool {
+	return cfg.TLSCertFile != "" && cfg.TLSKeyFile != ""
+}
+
+// buildTLSConfig builds the *tls.Config for ListenAndServeTLS, wiring up
+// client-certificate verification against TLSClientCAFile when set.
+func (cfg *Config) buildTLSConfig() (*tls.Config, error) {
+	if cfg.TLSClientCAFile == "" {
+		return nil, nil
+	}
+
+	caCert, err := os.ReadFile(cfg.TLSClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading TLS client CA file: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("no certificates found in %s", cfg.TLSClientCAFile)
+	}
+
+	return &tls.Config{
+		ClientCAs:  pool,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	}, nil
+}
+
+// basicAuthMiddleware enforces HTTP basic auth when cfg has credentials
+// configured; it's a transparent passthrough otherwise.
+func basicAuthMiddleware(cfg *Config, next http.Handler) http.Handler {
+	if cfg.BasicAuthUser == "" && cfg.BasicAuthPass == "" {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(user), []byte(cfg.BasicAuthUser)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(pass), []byte(cfg.BasicAuthPass)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="docker_swarm_exporter"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/gorilla/mux"
+)
+
+// registerLogRoutes wires the read-only log-streaming endpoints onto router.
+// They exist so operators can tail swarm service/task logs without being
+// handed direct access to the Docker socket.
+func registerLogRoutes(router *mux.Router, coll *DockerServices) {
+	router.HandleFunc("/services/{name}/logs", coll.serviceLogsHandler)
+	router.HandleFunc("/tasks/{id}/logs", coll.taskLogsHandler)
+}
+
+// logQueryOptions are the query params shared by both log endpoints.
+type logQueryOptions struct {
+	Follow     bool
+	Tail       string
+	Since      string
+	Timestamps bool
+	ShowStdout bool
+	ShowStderr bool
+}
+
+func parseLogQueryOptions(r *http.Request) logQueryOptions {
+	q := r.URL.Query()
+
+	opts := logQueryOptions{
+		Follow:     q.Get("follow") == "1",
+		Tail:       q.Get("tail"),
+		Since:      q.Get("since"),
+		Timestamps: q.Get("timestamps") == "1",
+		ShowStdout: true,
+		ShowStderr: true,
+	}
+	if opts.Tail == "" {
+		opts.Tail = "all"
+	}
+	if stdout := q.Get("stdout"); stdout != "" {
+		opts.ShowStdout = stdout == "1"
+	}
+	if stderr := q.Get("stderr"); stderr != "" {
+		opts.ShowStderr = stderr == "1"
+	}
+	return opts
+}
+
+func (c *DockerServices) serviceLogsHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	opts := parseLogQueryOptions(r)
+
+	reader, err := c.Client.ServiceLogs(r.Context(), name, types.ContainerLogsOptions{
+		ShowStdout: opts.ShowStdout,
+		ShowStderr: opts.ShowStderr,
+		Since:      opts.Since,
+		Timestamps: opts.Timestamps,
+		Follow:     opts.Follow,
+		Tail:       opts.Tail,
+	})
+	if err != nil {
+		logger.Error().Err(err).Str("service", name).Msgf("Error opening service log stream.")
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer reader.Close()
+
+	streamLogs(w, r, reader, opts.Follow)
+}
+
+func (c *DockerServices) taskLogsHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	opts := parseLogQueryOptions(r)
+
+	reader, err := c.Client.TaskLogs(r.Context(), id, types.ContainerLogsOptions{
+		ShowStdout: opts.ShowStdout,
+		ShowStderr: opts.ShowStderr,
+		Since:      opts.Since,
+		Timestamps: opts.Timestamps,
+		Follow:     opts.Follow,
+		Tail:       opts.Tail,
+	})
+	if err != nil {
+		logger.Error().Err(err).Str("task", id).Msgf("Error opening task log stream.")
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer reader.Close()
+
+	streamLogs(w, r, reader, opts.Follow)
+}
+
+// streamLogs demultiplexes the Docker stdcopy framing from reader and
+// writes it to w, as an SSE stream when follow is set (so proxies don't
+// buffer it) or as a single text/plain body otherwise. It stops as soon
+// as the request context is cancelled.
+func streamLogs(w http.ResponseWriter, r *http.Request, reader io.Reader, follow bool) {
+	if !follow {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if _, err := stdcopy.StdCopy(w, w, reader); err != nil && !isCancelled(r) {
+			logger.Warn().Err(err).Msgf("Error streaming logs.")
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := stdcopy.StdCopy(pw, pw, reader)
+		pw.CloseWithError(err)
+	}()
+
+	// pr.Read returns whatever stdcopy happened to write in one call, not
+	// line-aligned chunks, so a raw Split(buf, "\n") per read would cut
+	// lines in half across reads. Scanner buffers the trailing partial
+	// line until it sees the rest of it (or EOF), so every "data:" frame
+	// is a complete line.
+	scanner := bufio.NewScanner(pr)
+	for scanner.Scan() {
+		w.Write([]byte("data: " + scanner.Text() + "\n\n"))
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		default:
+		}
+	}
+	if err := scanner.Err(); err != nil && !isCancelled(r) {
+		logger.Warn().Err(err).Msgf("Error streaming logs.")
+	}
+	<-done
+}
+
+func isCancelled(r *http.Request) bool {
+	return r.Context().Err() != nil
+}
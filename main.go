@@ -11,79 +11,23 @@ import (
 	"os"
 	"time"
 
-	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
+	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
 )
 
 // Setting up the logger
 var log_output = zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC3339}
 var logger = zerolog.New(log_output).With().Timestamp().Logger()
 
-func main() {
-	// Set the envvar DEBUG=1 to enable debug logging.
-	if os.Getenv("DEBUG") == "1" {
-		zerolog.SetGlobalLevel(zerolog.DebugLevel)
-	} else {
-		zerolog.SetGlobalLevel(zerolog.InfoLevel)
-	}
-
-	// Init the docker client, use the DOCKER_HOST envvar to override the OS-default.
-	dockerClient, err := client.NewClientWithOpts(
-		client.FromEnv,
-		client.WithAPIVersionNegotiation(),
-	)
-
-	if err != nil {
-		logger.Fatal().Err(err).Msgf("Error while initialising Docker client.")
-	}
-
-	// Output some useful info
-	var docker_host = os.Getenv("DOCKER_HOST")
-	if docker_host == "" {
-		docker_host = client.DefaultDockerHost
-	}
-	logger.Info().Msgf("Docker client created using socket host: %s", docker_host)
-
-	// Setup the metrics collector
-	coll := DockerServices{Client: dockerClient}
-	if err := prometheus.Register(&coll); err != nil {
-		logger.Fatal().Err(err).Msgf("Error while registering metrics collector.")
-	}
-
-	// Test connectivity to the docker daemon
-	info, err := coll.Client.Info(context.Background())
-
-	if err != nil {
-		logger.Fatal().Err(err).Msgf("Error communicating with Docker Socket.")
-	}
-
-	logger.Info().Str("OS", info.OSType+" / "+info.OperatingSystem).Str("version", info.ServerVersion).Msgf("Connected to Docker Daemon")
-
-	// Get rid of the stupid golang metrics
-	prometheus.Unregister(collectors.NewGoCollector())
-
-	// Setup the HTTP routing
-	http.Handle("/metrics", promhttp.Handler())
-
-	// Start the HTTP server
-	logger.Info().Msgf("Starting HTTP Server on port TCP/9675")
-	err = http.ListenAndServe(":9675", nil)
-
-	if err != nil {
-		logger.Fatal().Err(err).Msgf("Error starting HTTP server.")
-	}
-}
-
-// DockerServices implements the Collector interface.
-type DockerServices struct {
-	*client.Client
-}
-
-var _ prometheus.Collector = (*DockerServices)(nil)
+// defaultResyncInterval is how often the cache is rebuilt from a full
+// listing even if the event stream stays healthy. Override with the
+// RESYNC_INTERVAL envvar, e.g. "60s".
+const defaultResyncInterval = 60 * time.Second
 
 var (
 	replicaCount = prometheus.NewDesc(
@@ -118,101 +62,133 @@ var (
 	)
 )
 
-func (c DockerServices) Describe(ch chan<- *prometheus.Desc) {
-	ch <- replicaCount
-	ch <- taskCount
-	ch <- imageVersion
-	ch <- lastChangeTime
+func main() {
+	cfg := &Config{}
+	rootCmd := &cobra.Command{
+		Use:   "docker_swarm_exporter",
+		Short: "Prometheus exporter for a Docker Swarm",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return run(cfg)
+		},
+	}
+	bindConfigFlags(rootCmd, cfg)
+
+	if err := rootCmd.Execute(); err != nil {
+		logger.Fatal().Err(err).Msgf("Exiting due to error.")
+	}
 }
 
-// Collect scrapes the container information from Docker.
-func (c DockerServices) Collect(ch chan<- prometheus.Metric) {
-	logger.Debug().Msgf("Received request for metrics.")
-	services, err := c.Client.ServiceList(context.Background(), types.ServiceListOptions{})
-	if err != nil {
-		logger.Fatal().Err(err).Msgf("Error listing Swarm Services.")
+func run(cfg *Config) error {
+	// Set the envvar DEBUG=1 to enable debug logging.
+	if os.Getenv("DEBUG") == "1" {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	} else {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
 	}
 
-	tasks, err := c.Client.TaskList(context.Background(), types.TaskListOptions{})
+	// Init the docker client, use the DOCKER_HOST envvar to override the OS-default.
+	dockerClient, err := client.NewClientWithOpts(
+		client.FromEnv,
+		client.WithAPIVersionNegotiation(),
+	)
+
 	if err != nil {
-		logger.Fatal().Err(err).Msgf("Error listing Swarm Tasks.")
+		logger.Fatal().Err(err).Msgf("Error while initialising Docker client.")
+	}
+
+	// Output some useful info
+	var docker_host = os.Getenv("DOCKER_HOST")
+	if docker_host == "" {
+		docker_host = client.DefaultDockerHost
 	}
+	logger.Info().Msgf("Docker client created using socket host: %s", docker_host)
+
+	// Test connectivity to the docker daemon
+	info, err := dockerClient.Info(context.Background())
 
-	containers, err := c.Client.ContainerList(context.Background(), types.ContainerListOptions{})
 	if err != nil {
-		logger.Fatal().Err(err).Msgf("Error listing Docker Containers.")
+		logger.Fatal().Err(err).Msgf("Error communicating with Docker Socket.")
 	}
 
-	for _, container := range containers {
-		container_json, err := c.Client.ContainerInspect(context.Background(), container.ID)
+	logger.Info().Str("OS", info.OSType+" / "+info.OperatingSystem).Str("version", info.ServerVersion).Msgf("Connected to Docker Daemon")
+
+	resyncInterval := defaultResyncInterval
+	if raw := os.Getenv("RESYNC_INTERVAL"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
 		if err != nil {
-			logger.Fatal().Err(err).Msgf("Error inspecting Docker Container details.")
+			logger.Fatal().Err(err).Msgf("Invalid RESYNC_INTERVAL %q.", raw)
 		}
+		resyncInterval = parsed
+	}
 
-		ch <- prometheus.MustNewConstMetric(
-			containerStatus,
-			prometheus.GaugeValue,
-			float64(1),
-			container_json.State.Status,
-			container_json.Name,
-		)
-
-		if container_json.State.Health != nil {
-			ch <- prometheus.MustNewConstMetric(
-				containerHealthStatus,
-				prometheus.GaugeValue,
-				float64(1),
-				container_json.State.Health.Status,
-				container_json.Name,
-			)
-		}
+	// Setup the metrics collector and prime its cache before we start serving traffic.
+	coll := NewDockerServices(dockerClient, resyncInterval)
+	if statsEnabled() {
+		coll.stats = newStatsCollector(coll)
+		logger.Info().Msgf("Per-container resource stats enabled (ENABLE_STATS=1).")
+	}
+	if err := coll.Run(context.Background()); err != nil {
+		logger.Fatal().Err(err).Msgf("Error priming Docker state cache.")
 	}
 
-	for _, service := range services {
-		if service.Spec.Mode.Replicated != nil {
-			ch <- prometheus.MustNewConstMetric(
-				replicaCount,
-				prometheus.GaugeValue,
-				float64(*service.Spec.Mode.Replicated.Replicas),
-				service.Spec.Annotations.Name,
-			)
-		}
+	if err := prometheus.Register(coll); err != nil {
+		logger.Fatal().Err(err).Msgf("Error while registering metrics collector.")
+	}
 
-		taskStates := make(map[string]int)
-		var lastTaskStatusChange time.Time
-		for _, task := range tasks {
-			if task.ServiceID == service.ID {
-				taskStates[string(task.Status.State)] += 1
-				if task.Status.Timestamp.After(lastTaskStatusChange) {
-					lastTaskStatusChange = task.Status.Timestamp
+	// Get rid of the stupid golang metrics, unless the operator wants them back.
+	if cfg.EnableGoMetrics {
+		// The default registry already carries both collectors, so an
+		// AlreadyRegisteredError here is expected and ignored.
+		for _, gc := range []prometheus.Collector{
+			collectors.NewGoCollector(),
+			collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		} {
+			if err := prometheus.Register(gc); err != nil {
+				if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
+					logger.Fatal().Err(err).Msgf("Error registering Go runtime metrics collector.")
 				}
 			}
 		}
+		logger.Info().Msgf("Go runtime and process metrics enabled (ENABLE_GO_METRICS=1).")
+	} else {
+		prometheus.Unregister(collectors.NewGoCollector())
+	}
+
+	// Setup the HTTP routing
+	router := mux.NewRouter()
+	router.Handle(cfg.MetricsPath, promhttp.Handler())
+	registerLogRoutes(router, coll)
+
+	if cfg.EnablePprof {
+		registerPprofRoutes(router)
+		logger.Info().Msgf("pprof endpoints enabled under /debug/pprof (ENABLE_PPROF=1).")
+	}
+
+	// Wrap the whole router, not just /metrics, so basic auth also covers
+	// the log-streaming and pprof endpoints when credentials are set.
+	server := &http.Server{
+		Addr:    cfg.ListenAddress,
+		Handler: basicAuthMiddleware(cfg, router),
+	}
+
+	if cfg.tlsEnabled() {
+		tlsConfig, err := cfg.buildTLSConfig()
+		if err != nil {
+			logger.Fatal().Err(err).Msgf("Error building TLS config.")
+		}
+		server.TLSConfig = tlsConfig
 
-		for state, count := range taskStates {
-			ch <- prometheus.MustNewConstMetric(
-				taskCount,
-				prometheus.GaugeValue,
-				float64(count),
-				service.Spec.Annotations.Name,
-				string(state),
-			)
+		logger.Info().Msgf("Starting HTTPS Server on %s", cfg.ListenAddress)
+		err = server.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			logger.Fatal().Err(err).Msgf("Error starting HTTPS server.")
 		}
+		return nil
+	}
 
-		// See https://www.robustperception.io/exposing-the-software-version-to-prometheus
-		ch <- prometheus.MustNewConstMetric(
-			imageVersion,
-			prometheus.GaugeValue,
-			1,
-			service.Spec.Annotations.Name,
-			string(service.Spec.TaskTemplate.ContainerSpec.Image),
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			lastChangeTime,
-			prometheus.GaugeValue,
-			float64(lastTaskStatusChange.Unix()),
-			service.Spec.Annotations.Name,
-		)
+	logger.Info().Msgf("Starting HTTP Server on %s", cfg.ListenAddress)
+	if err := server.ListenAndServe(); err != nil {
+		logger.Fatal().Err(err).Msgf("Error starting HTTP server.")
 	}
+	return nil
 }
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/docker/docker/api/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	nodeInfo = prometheus.NewDesc(
+		"swarm_node_info",
+		"Information about each node in the swarm",
+		[]string{"node_id", "hostname", "role", "engine_version", "platform_os", "platform_arch"}, nil,
+	)
+	nodeAvailability = prometheus.NewDesc(
+		"swarm_node_availability",
+		"Scheduling availability of a node",
+		[]string{"node_id", "availability"}, nil,
+	)
+	nodeStatus = prometheus.NewDesc(
+		"swarm_node_status",
+		"Status of a node",
+		[]string{"node_id", "state", "addr"}, nil,
+	)
+	nodeManagerReachability = prometheus.NewDesc(
+		"swarm_node_manager_reachability",
+		"Raft reachability of a manager node",
+		[]string{"node_id", "reachability"}, nil,
+	)
+	nodeIsLeader = prometheus.NewDesc(
+		"swarm_manager_is_leader",
+		"Whether this manager node is the current Raft leader",
+		[]string{"node_id"}, nil,
+	)
+	swarmInfo = prometheus.NewDesc(
+		"swarm_info",
+		"Information about the swarm cluster",
+		[]string{"cluster_id", "version"}, nil,
+	)
+	swarmManagersTotal = prometheus.NewDesc(
+		"swarm_managers_total",
+		"Number of manager nodes in the swarm",
+		nil, nil,
+	)
+	swarmNodesTotal = prometheus.NewDesc(
+		"swarm_nodes_total",
+		"Number of nodes in the swarm",
+		nil, nil,
+	)
+)
+
+// reconcile also refreshes c.nodes and c.cluster, so Collect can emit
+// cluster-wide metrics alongside the per-service ones. A worker/non-manager
+// node will fail NodeList with a permission error, which is logged
+// at debug level rather than failing the whole reconcile, but still counted
+// under swarmExporterScrapeErrors so a genuine manager-side failure is
+// visible in metrics rather than silently dropped.
+func (c *DockerServices) reconcileNodes(ctx context.Context) {
+	nodes, err := c.Client.NodeList(ctx, types.NodeListOptions{})
+	if err != nil {
+		swarmExporterScrapeErrors.WithLabelValues("node_list").Inc()
+		logger.Debug().Err(err).Msgf("Error listing Swarm nodes (this daemon may not be a manager).")
+		return
+	}
+
+	info, err := c.Client.Info(ctx)
+	if err != nil {
+		swarmExporterScrapeErrors.WithLabelValues("info").Inc()
+		logger.Debug().Err(err).Msgf("Error fetching Docker info.")
+		return
+	}
+
+	c.mu.Lock()
+	c.nodes = nodes
+	c.cluster = info.Swarm.Cluster
+	c.mu.Unlock()
+}
+
+func (c *DockerServices) collectNodes(ch chan<- prometheus.Metric) {
+	for _, node := range c.nodes {
+		ch <- prometheus.MustNewConstMetric(
+			nodeInfo,
+			prometheus.GaugeValue,
+			1,
+			node.ID,
+			node.Description.Hostname,
+			string(node.Spec.Role),
+			node.Description.Engine.EngineVersion,
+			node.Description.Platform.OS,
+			node.Description.Platform.Architecture,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			nodeAvailability,
+			prometheus.GaugeValue,
+			1,
+			node.ID,
+			string(node.Spec.Availability),
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			nodeStatus,
+			prometheus.GaugeValue,
+			1,
+			node.ID,
+			string(node.Status.State),
+			node.Status.Addr,
+		)
+
+		if node.ManagerStatus != nil {
+			ch <- prometheus.MustNewConstMetric(
+				nodeManagerReachability,
+				prometheus.GaugeValue,
+				1,
+				node.ID,
+				string(node.ManagerStatus.Reachability),
+			)
+
+			isLeader := float64(0)
+			if node.ManagerStatus.Leader {
+				isLeader = 1
+			}
+			ch <- prometheus.MustNewConstMetric(nodeIsLeader, prometheus.GaugeValue, isLeader, node.ID)
+		}
+	}
+
+	if c.cluster != nil {
+		ch <- prometheus.MustNewConstMetric(
+			swarmInfo,
+			prometheus.GaugeValue,
+			1,
+			c.cluster.ID,
+			strconv.FormatUint(c.cluster.Meta.Version.Index, 10),
+		)
+	}
+
+	var managers int
+	for _, node := range c.nodes {
+		if node.ManagerStatus != nil {
+			managers++
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(swarmManagersTotal, prometheus.GaugeValue, float64(managers))
+	ch <- prometheus.MustNewConstMetric(swarmNodesTotal, prometheus.GaugeValue, float64(len(c.nodes)))
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"net/http"
+	_ "net/http/pprof" // registers the pprof handlers on http.DefaultServeMux
+
+	"github.com/gorilla/mux"
+)
+
+// registerPprofRoutes mounts the net/http/pprof handlers under /debug/pprof
+// on router. Importing net/http/pprof registers them on http.DefaultServeMux,
+// so we just delegate that prefix to it rather than re-registering each handler.
+func registerPprofRoutes(router *mux.Router) {
+	router.PathPrefix("/debug/pprof/").Handler(http.DefaultServeMux)
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// defaultStatsInterval is how often each per-container stats goroutine
+// polls the Docker stats API.
+const defaultStatsInterval = 15 * time.Second
+
+// defaultStatsConcurrency caps how many ContainerStats calls can be
+// in flight at once, so a swarm with hundreds of containers doesn't open
+// hundreds of simultaneous stats connections to the daemon.
+const defaultStatsConcurrency = 10
+
+var (
+	containerCPUUsageSeconds = prometheus.NewDesc(
+		"container_cpu_usage_seconds_total",
+		"Cumulative CPU time consumed by the container, in seconds",
+		[]string{"container_name", "service_name"}, nil,
+	)
+	containerCPUUsageDelta = prometheus.NewDesc(
+		"container_cpu_usage_delta_percent",
+		"CPU usage as a percentage of a single CPU, measured over the last stats sample",
+		[]string{"container_name", "service_name"}, nil,
+	)
+	containerMemoryUsageBytes = prometheus.NewDesc(
+		"container_memory_usage_bytes",
+		"Current memory usage in bytes",
+		[]string{"container_name", "service_name"}, nil,
+	)
+	containerMemoryLimitBytes = prometheus.NewDesc(
+		"container_memory_limit_bytes",
+		"Memory limit in bytes",
+		[]string{"container_name", "service_name"}, nil,
+	)
+	containerMemoryWorkingSetBytes = prometheus.NewDesc(
+		"container_memory_working_set_bytes",
+		"Working set memory in bytes (usage minus reclaimable page cache)",
+		[]string{"container_name", "service_name"}, nil,
+	)
+	containerNetworkReceiveBytes = prometheus.NewDesc(
+		"container_network_receive_bytes_total",
+		"Bytes received on a network interface",
+		[]string{"container_name", "service_name", "interface"}, nil,
+	)
+	containerNetworkTransmitBytes = prometheus.NewDesc(
+		"container_network_transmit_bytes_total",
+		"Bytes transmitted on a network interface",
+		[]string{"container_name", "service_name", "interface"}, nil,
+	)
+	containerNetworkReceivePackets = prometheus.NewDesc(
+		"container_network_receive_packets_total",
+		"Packets received on a network interface",
+		[]string{"container_name", "service_name", "interface"}, nil,
+	)
+	containerNetworkTransmitPackets = prometheus.NewDesc(
+		"container_network_transmit_packets_total",
+		"Packets transmitted on a network interface",
+		[]string{"container_name", "service_name", "interface"}, nil,
+	)
+	containerBlkioReadBytes = prometheus.NewDesc(
+		"container_fs_reads_bytes_total",
+		"Bytes read from block devices",
+		[]string{"container_name", "service_name"}, nil,
+	)
+	containerBlkioWriteBytes = prometheus.NewDesc(
+		"container_fs_writes_bytes_total",
+		"Bytes written to block devices",
+		[]string{"container_name", "service_name"}, nil,
+	)
+	statsScrapeDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "docker_stats_scrape_duration_seconds",
+		Help: "Time taken to fetch stats for a single container from the Docker API",
+	})
+)
+
+// containerStats is the derived snapshot kept per container. It's
+// refreshed by a dedicated background goroutine rather than on-demand,
+// because ContainerStats is expensive relative to a Prometheus scrape.
+type containerStats struct {
+	containerName    string
+	serviceName      string
+	cpuTotalUsage    float64
+	cpuDeltaPct      float64
+	memoryUsage      uint64
+	memoryLimit      uint64
+	memoryWorkingSet uint64
+	networkRxBytes   map[string]uint64
+	networkTxBytes   map[string]uint64
+	networkRxPackets map[string]uint64
+	networkTxPackets map[string]uint64
+	blkioReadBytes   uint64
+	blkioWriteBytes  uint64
+}
+
+// statsCollector runs one background goroutine per container polling
+// client.ContainerStats, bounded by a semaphore-based concurrency cap,
+// and stores the derived results for the main Collect loop to emit.
+type statsCollector struct {
+	client      *DockerServices
+	concurrency chan struct{}
+
+	mu    sync.RWMutex
+	stats map[string]containerStats
+
+	mu2      sync.Mutex
+	watching map[string]context.CancelFunc
+}
+
+// statsEnabled reports whether ENABLE_STATS=1 was set.
+func statsEnabled() bool {
+	return os.Getenv("ENABLE_STATS") == "1"
+}
+
+func newStatsCollector(c *DockerServices) *statsCollector {
+	concurrency := defaultStatsConcurrency
+	if raw := os.Getenv("STATS_CONCURRENCY"); raw != "" {
+		if parsed, err := strconv.Atoi(raw); err == nil && parsed > 0 {
+			concurrency = parsed
+		}
+	}
+
+	prometheus.MustRegister(statsScrapeDuration)
+
+	return &statsCollector{
+		client:      c,
+		concurrency: make(chan struct{}, concurrency),
+		stats:       make(map[string]containerStats),
+		watching:    make(map[string]context.CancelFunc),
+	}
+}
+
+// reconcileWatchers starts a stats goroutine for every running container
+// that doesn't already have one, and stops those for containers that
+// have disappeared since the last call.
+func (s *statsCollector) reconcileWatchers(ctx context.Context, containers []types.ContainerJSON) {
+	s.mu2.Lock()
+	defer s.mu2.Unlock()
+
+	seen := make(map[string]struct{}, len(containers))
+	for _, container := range containers {
+		if container.State == nil || container.State.Status != "running" {
+			continue
+		}
+		seen[container.ID] = struct{}{}
+		if _, ok := s.watching[container.ID]; ok {
+			continue
+		}
+
+		watchCtx, cancel := context.WithCancel(ctx)
+		s.watching[container.ID] = cancel
+		go s.watch(watchCtx, container.ID, container.Name, container.Config.Labels["com.docker.swarm.service.name"])
+	}
+
+	for id, cancel := range s.watching {
+		if _, ok := seen[id]; !ok {
+			cancel()
+			delete(s.watching, id)
+			s.mu.Lock()
+			delete(s.stats, id)
+			s.mu.Unlock()
+		}
+	}
+}
+
+// watch polls stats for a single container until ctx is cancelled.
+func (s *statsCollector) watch(ctx context.Context, containerID, containerName, serviceName string) {
+	ticker := time.NewTicker(defaultStatsInterval)
+	defer ticker.Stop()
+
+	for {
+		s.poll(ctx, containerID, containerName, serviceName)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *statsCollector) poll(ctx context.Context, containerID, containerName, serviceName string) {
+	select {
+	case s.concurrency <- struct{}{}:
+	case <-ctx.Done():
+		return
+	}
+	defer func() { <-s.concurrency }()
+
+	start := time.Now()
+	resp, err := s.client.Client.ContainerStats(ctx, containerID, false)
+	if err != nil {
+		logger.Debug().Err(err).Str("container", containerID).Msgf("Error fetching container stats.")
+		return
+	}
+	defer resp.Body.Close()
+
+	var raw types.StatsJSON
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		logger.Debug().Err(err).Str("container", containerID).Msgf("Error decoding container stats.")
+		return
+	}
+	statsScrapeDuration.Observe(time.Since(start).Seconds())
+
+	derived := deriveContainerStats(raw, containerName, serviceName)
+
+	s.mu.Lock()
+	s.stats[containerID] = derived
+	s.mu.Unlock()
+}
+
+func deriveContainerStats(raw types.StatsJSON, containerName, serviceName string) containerStats {
+	cpuDelta := float64(raw.CPUStats.CPUUsage.TotalUsage) - float64(raw.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(raw.CPUStats.SystemUsage) - float64(raw.PreCPUStats.SystemUsage)
+	var cpuPct float64
+	if systemDelta > 0 && cpuDelta > 0 {
+		onlineCPUs := float64(raw.CPUStats.OnlineCPUs)
+		if onlineCPUs == 0 {
+			onlineCPUs = float64(len(raw.CPUStats.CPUUsage.PercpuUsage))
+		}
+		if onlineCPUs == 0 {
+			onlineCPUs = 1
+		}
+		cpuPct = (cpuDelta / systemDelta) * onlineCPUs * 100.0
+	}
+
+	workingSet := raw.MemoryStats.Usage
+	if cache, ok := raw.MemoryStats.Stats["cache"]; ok && cache < workingSet {
+		workingSet -= cache
+	}
+
+	rxBytes := make(map[string]uint64, len(raw.Networks))
+	txBytes := make(map[string]uint64, len(raw.Networks))
+	rxPackets := make(map[string]uint64, len(raw.Networks))
+	txPackets := make(map[string]uint64, len(raw.Networks))
+	for iface, net := range raw.Networks {
+		rxBytes[iface] = net.RxBytes
+		txBytes[iface] = net.TxBytes
+		rxPackets[iface] = net.RxPackets
+		txPackets[iface] = net.TxPackets
+	}
+
+	var blkioRead, blkioWrite uint64
+	for _, entry := range raw.BlkioStats.IoServiceBytesRecursive {
+		switch entry.Op {
+		case "Read", "read":
+			blkioRead += entry.Value
+		case "Write", "write":
+			blkioWrite += entry.Value
+		}
+	}
+
+	return containerStats{
+		containerName:    containerName,
+		serviceName:      serviceName,
+		cpuTotalUsage:    float64(raw.CPUStats.CPUUsage.TotalUsage) / 1e9,
+		cpuDeltaPct:      cpuPct,
+		memoryUsage:      raw.MemoryStats.Usage,
+		memoryLimit:      raw.MemoryStats.Limit,
+		memoryWorkingSet: workingSet,
+		networkRxBytes:   rxBytes,
+		networkTxBytes:   txBytes,
+		networkRxPackets: rxPackets,
+		networkTxPackets: txPackets,
+		blkioReadBytes:   blkioRead,
+		blkioWriteBytes:  blkioWrite,
+	}
+}
+
+// collect emits the cached stats snapshots. Called from DockerServices.Collect.
+func (s *statsCollector) collect(ch chan<- prometheus.Metric) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, st := range s.stats {
+		ch <- prometheus.MustNewConstMetric(containerCPUUsageSeconds, prometheus.CounterValue, st.cpuTotalUsage, st.containerName, st.serviceName)
+		ch <- prometheus.MustNewConstMetric(containerCPUUsageDelta, prometheus.GaugeValue, st.cpuDeltaPct, st.containerName, st.serviceName)
+		ch <- prometheus.MustNewConstMetric(containerMemoryUsageBytes, prometheus.GaugeValue, float64(st.memoryUsage), st.containerName, st.serviceName)
+		ch <- prometheus.MustNewConstMetric(containerMemoryLimitBytes, prometheus.GaugeValue, float64(st.memoryLimit), st.containerName, st.serviceName)
+		ch <- prometheus.MustNewConstMetric(containerMemoryWorkingSetBytes, prometheus.GaugeValue, float64(st.memoryWorkingSet), st.containerName, st.serviceName)
+		ch <- prometheus.MustNewConstMetric(containerBlkioReadBytes, prometheus.CounterValue, float64(st.blkioReadBytes), st.containerName, st.serviceName)
+		ch <- prometheus.MustNewConstMetric(containerBlkioWriteBytes, prometheus.CounterValue, float64(st.blkioWriteBytes), st.containerName, st.serviceName)
+
+		for iface, bytes := range st.networkRxBytes {
+			ch <- prometheus.MustNewConstMetric(containerNetworkReceiveBytes, prometheus.CounterValue, float64(bytes), st.containerName, st.serviceName, iface)
+		}
+		for iface, bytes := range st.networkTxBytes {
+			ch <- prometheus.MustNewConstMetric(containerNetworkTransmitBytes, prometheus.CounterValue, float64(bytes), st.containerName, st.serviceName, iface)
+		}
+		for iface, packets := range st.networkRxPackets {
+			ch <- prometheus.MustNewConstMetric(containerNetworkReceivePackets, prometheus.CounterValue, float64(packets), st.containerName, st.serviceName, iface)
+		}
+		for iface, packets := range st.networkTxPackets {
+			ch <- prometheus.MustNewConstMetric(containerNetworkTransmitPackets, prometheus.CounterValue, float64(packets), st.containerName, st.serviceName, iface)
+		}
+	}
+}